@@ -36,7 +36,8 @@ func (hbu *httpBlobUpload) handleErrorResponse(resp *http.Response) error {
 }
 
 func (hbu *httpBlobUpload) ReadFrom(r io.Reader) (n int64, err error) {
-	req, err := http.NewRequest("PATCH", hbu.location, ioutil.NopCloser(r))
+	cr := &countingReader{reader: r}
+	req, err := http.NewRequest("PATCH", hbu.location, ioutil.NopCloser(cr))
 	if err != nil {
 		return 0, err
 	}
@@ -63,11 +64,26 @@ func (hbu *httpBlobUpload) ReadFrom(r io.Reader) (n int64, err error) {
 	} else if n != 2 || end < start {
 		return 0, fmt.Errorf("bad range format: %s", rng)
 	}
+	// The registry reports the range of all data received for the upload,
+	// not just this request.
+	hbu.offset = end + 1
 
-	return (end - start + 1), nil
+	return cr.n, nil
 
 }
 
+// countingReader counts the bytes read from the underlying reader.
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
 func (hbu *httpBlobUpload) Write(p []byte) (n int, err error) {
 	req, err := http.NewRequest("PATCH", hbu.location, bytes.NewReader(p))
 	if err != nil {
@@ -98,8 +114,11 @@ func (hbu *httpBlobUpload) Write(p []byte) (n int, err error) {
 	} else if n != 2 || end < start {
 		return 0, fmt.Errorf("bad range format: %s", rng)
 	}
+	// The registry reports the range of all data received for the upload,
+	// not just this chunk.
+	hbu.offset = int64(end + 1)
 
-	return (end - start + 1), nil
+	return len(p), nil
 
 }
 
@@ -111,6 +130,13 @@ func (hbu *httpBlobUpload) ID() string {
 	return hbu.uuid
 }
 
+// Location returns the URL of the upload, including any upload state the
+// registry requires. It may be saved and later passed to Resume to continue
+// the upload.
+func (hbu *httpBlobUpload) Location() string {
+	return hbu.location
+}
+
 func (hbu *httpBlobUpload) StartedAt() time.Time {
 	return hbu.startedAt
 }
@@ -16,6 +16,12 @@ import (
 // errcode.Errors slice.
 var ErrNoErrorsInBody = errors.New("no error details found in HTTP response body")
 
+// ErrBlobUploadOffsetAmbiguous is returned when resuming an upload for which
+// the registry reports a range of "0-0". The same range is used for an empty
+// upload and for one holding a single byte, so the upload cannot be continued
+// safely and should be started again.
+var ErrBlobUploadOffsetAmbiguous = errors.New("blob upload offset is ambiguous")
+
 // UnexpectedHTTPStatusError is returned when an unexpected HTTP status is
 // returned when making a registry api call.
 type UnexpectedHTTPStatusError struct {
@@ -38,6 +44,16 @@ func (e *UnexpectedHTTPResponseError) Error() string {
 	return fmt.Sprintf("error parsing HTTP %d response body: %s: %q", e.StatusCode, e.ParseErr.Error(), string(e.Response))
 }
 
+// ManifestTooLargeError is returned when a manifest body exceeds the
+// maximum size the client is willing to read.
+type ManifestTooLargeError struct {
+	Limit int64
+}
+
+func (e *ManifestTooLargeError) Error() string {
+	return fmt.Sprintf("manifest exceeds maximum size of %d bytes", e.Limit)
+}
+
 func parseHTTPErrorResponse(statusCode int, r io.Reader) error {
 	var errors errcode.Errors
 	body, err := ioutil.ReadAll(r)
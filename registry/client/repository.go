@@ -10,16 +10,19 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema1"
 	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/api/v2"
 	"github.com/docker/distribution/registry/client/transport"
 	"github.com/docker/distribution/registry/storage/cache"
 	"github.com/docker/distribution/registry/storage/cache/memory"
+	"github.com/docker/libtrust"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -61,8 +64,35 @@ func checkHTTPRedirect(req *http.Request, via []*http.Request) error {
 	return nil
 }
 
+// validateBaseURL ensures the base URL of a registry is an absolute http or
+// https URL, so that a malformed endpoint fails early with a clear error.
+func validateBaseURL(baseURL string) error {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return fmt.Errorf("invalid registry base URL %q: %v", baseURL, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+	case "":
+		return fmt.Errorf("invalid registry base URL %q: missing scheme", baseURL)
+	default:
+		return fmt.Errorf("invalid registry base URL %q: unsupported scheme %q", baseURL, u.Scheme)
+	}
+
+	if u.Host == "" {
+		return fmt.Errorf("invalid registry base URL %q: missing host", baseURL)
+	}
+
+	return nil
+}
+
 // NewRegistry creates a registry namespace which can be used to get a listing of repositories
 func NewRegistry(baseURL string, transport http.RoundTripper) (Registry, error) {
+	if err := validateBaseURL(baseURL); err != nil {
+		return nil, err
+	}
+
 	ub, err := v2.NewURLBuilderFromString(baseURL, false)
 	if err != nil {
 		return nil, err
@@ -132,6 +162,10 @@ func (r *registry) Repositories(ctx context.Context, entries []string, last stri
 
 // NewRepository creates a new Repository for the given repository name and base URL.
 func NewRepository(name reference.Named, baseURL string, transport http.RoundTripper) (distribution.Repository, error) {
+	if err := validateBaseURL(baseURL); err != nil {
+		return nil, err
+	}
+
 	ub, err := v2.NewURLBuilderFromString(baseURL, false)
 	if err != nil {
 		return nil, err
@@ -421,6 +455,71 @@ func (o contentDigestOption) Apply(ms distribution.ManifestService) error {
 	return nil
 }
 
+// DefaultMaxManifestSize is the largest manifest body, in bytes, read by
+// the client unless a different limit is set with WithMaxManifestSize.
+const DefaultMaxManifestSize = 4 << 20
+
+// WithMaxManifestSize sets the largest manifest body, in bytes, that Get
+// will read. Larger manifests are rejected with a ManifestTooLargeError.
+func WithMaxManifestSize(size int64) distribution.ManifestServiceOption {
+	return maxManifestSizeOption{size}
+}
+
+type maxManifestSizeOption struct{ size int64 }
+
+func (o maxManifestSizeOption) Apply(ms distribution.ManifestService) error {
+	return nil
+}
+
+// RequireSignature returns a ManifestServiceOption which causes Get to
+// reject manifests without a valid JWS signature. Only schema1 manifests
+// carry signatures, so all other manifest types are rejected as well.
+func RequireSignature() distribution.ManifestServiceOption {
+	return requireSignatureOption{}
+}
+
+type requireSignatureOption struct{}
+
+func (o requireSignatureOption) Apply(ms distribution.ManifestService) error {
+	return nil
+}
+
+// checkManifestSigned returns an error unless the manifest body carries at
+// least one JWS signature. Bodies whose signatures were stripped cannot be
+// unmarshaled as schema1 manifests, so this is checked on the raw body to
+// report them clearly.
+func checkManifestSigned(body []byte) error {
+	jsig, err := libtrust.ParsePrettySignature(body, "signatures")
+	if err != nil {
+		return distribution.ErrManifestVerification{err}
+	}
+
+	signatures, err := jsig.Signatures()
+	if err != nil {
+		return distribution.ErrManifestVerification{err}
+	}
+	if len(signatures) == 0 {
+		return distribution.ErrManifestVerification{errors.New("manifest is not signed")}
+	}
+
+	return nil
+}
+
+// verifyManifestSignature checks that the manifest is a schema1 manifest
+// whose signatures are all valid.
+func verifyManifestSignature(m distribution.Manifest) error {
+	sm, ok := m.(*schema1.SignedManifest)
+	if !ok {
+		return distribution.ErrManifestVerification{errors.New("manifest type does not support signatures")}
+	}
+
+	if _, err := schema1.Verify(sm); err != nil {
+		return distribution.ErrManifestVerification{err}
+	}
+
+	return nil
+}
+
 func (ms *manifests) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
 	var (
 		digestOrTag string
@@ -428,6 +527,8 @@ func (ms *manifests) Get(ctx context.Context, dgst digest.Digest, options ...dis
 		err         error
 		contentDgst *digest.Digest
 		mediaTypes  []string
+		maxSize     int64 = DefaultMaxManifestSize
+		requireSig  bool
 	)
 
 	for _, option := range options {
@@ -442,6 +543,10 @@ func (ms *manifests) Get(ctx context.Context, dgst digest.Digest, options ...dis
 			contentDgst = opt.digest
 		case distribution.WithManifestMediaTypesOption:
 			mediaTypes = opt.MediaTypes
+		case maxManifestSizeOption:
+			maxSize = opt.size
+		case requireSignatureOption:
+			requireSig = true
 		default:
 			err := option.Apply(ms)
 			if err != nil {
@@ -494,42 +599,107 @@ func (ms *manifests) Get(ctx context.Context, dgst digest.Digest, options ...dis
 				*contentDgst = dgst
 			}
 		}
+		if resp.ContentLength > maxSize {
+			return nil, &ManifestTooLargeError{Limit: maxSize}
+		}
 		mt := resp.Header.Get("Content-Type")
-		body, err := ioutil.ReadAll(resp.Body)
+		body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxSize+1))
 
 		if err != nil {
 			return nil, err
 		}
+		if int64(len(body)) > maxSize {
+			return nil, &ManifestTooLargeError{Limit: maxSize}
+		}
+		if requireSig {
+			if err := checkManifestSigned(body); err != nil {
+				return nil, err
+			}
+		}
 		m, _, err := distribution.UnmarshalManifest(mt, body)
 		if err != nil {
 			return nil, err
 		}
+		if requireSig {
+			if err := verifyManifestSignature(m); err != nil {
+				return nil, err
+			}
+		}
 		return m, nil
 	}
 	return nil, HandleErrorResponse(resp)
 }
 
+// AllowEmptyLayers returns a ManifestServiceOption which disables the check
+// on Put that rejects schema1 manifests without layers or with empty blob
+// sums.
+func AllowEmptyLayers() distribution.ManifestServiceOption {
+	return allowEmptyLayersOption{}
+}
+
+type allowEmptyLayersOption struct{}
+
+func (o allowEmptyLayersOption) Apply(ms distribution.ManifestService) error {
+	return nil
+}
+
+// verifyManifestLayers checks that a schema1 manifest references at least
+// one layer and that none of its blob sums are empty. Such manifests are
+// almost always the result of a bug in the caller.
+func verifyManifestLayers(m distribution.Manifest) error {
+	sm, ok := m.(*schema1.SignedManifest)
+	if !ok {
+		return nil
+	}
+
+	if len(sm.FSLayers) == 0 {
+		return distribution.ErrManifestVerification{errors.New("manifest has no layers")}
+	}
+
+	var errs distribution.ErrManifestVerification
+	for i, fsLayer := range sm.FSLayers {
+		if fsLayer.BlobSum == "" {
+			errs = append(errs, fmt.Errorf("layer %d has an empty blob sum", i))
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+
+	return nil
+}
+
 // Put puts a manifest.  A tag can be specified using an options parameter which uses some shared state to hold the
 // tag name in order to build the correct upload URL.
 func (ms *manifests) Put(ctx context.Context, m distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	ref := ms.name
-	var tagged bool
+	var tagged, allowEmptyLayers bool
 
 	for _, option := range options {
-		if opt, ok := option.(distribution.WithTagOption); ok {
+		switch opt := option.(type) {
+		case distribution.WithTagOption:
 			var err error
 			ref, err = reference.WithTag(ref, opt.Tag)
 			if err != nil {
 				return "", err
 			}
 			tagged = true
-		} else {
+		case allowEmptyLayersOption:
+			allowEmptyLayers = true
+		default:
 			err := option.Apply(ms)
 			if err != nil {
 				return "", err
 			}
 		}
 	}
+
+	if !allowEmptyLayers {
+		if err := verifyManifestLayers(m); err != nil {
+			return "", err
+		}
+	}
+
 	mediaType, p, err := m.Payload()
 	if err != nil {
 		return "", err
@@ -657,13 +827,17 @@ func (bs *blobs) Open(ctx context.Context, dgst digest.Digest) (distribution.Rea
 		return nil, err
 	}
 
-	return transport.NewHTTPReadSeeker(bs.client, blobURL,
+	rsc := transport.NewHTTPReadSeeker(bs.client, blobURL,
 		func(resp *http.Response) error {
 			if resp.StatusCode == http.StatusNotFound {
 				return distribution.ErrBlobUnknown
 			}
 			return HandleErrorResponse(resp)
-		}), nil
+		})
+
+	// Interrupted reads are resumed with range requests, so verify the
+	// content spliced together from several responses.
+	return newVerifyingReadSeeker(rsc, dgst), nil
 }
 
 func (bs *blobs) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
@@ -769,8 +943,83 @@ func (bs *blobs) Create(ctx context.Context, options ...distribution.BlobCreateO
 	}
 }
 
+// Resume returns a BlobWriter for the upload identified by id. The id may
+// either be an upload UUID or, for registries which carry upload state in
+// the upload URL, the value of the writer's Location method saved from an
+// earlier session. The upload status is fetched from the registry so that
+// subsequent writes continue from the last offset acknowledged by the
+// server.
 func (bs *blobs) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
-	panic("not implemented")
+	var (
+		u    string
+		base string
+		err  error
+	)
+	if strings.Contains(id, "/") {
+		// Resolve a saved location against the registry in case it was
+		// returned as a relative URL.
+		base, err = bs.ub.BuildBlobUploadURL(bs.name)
+		if err != nil {
+			return nil, err
+		}
+		u, err = sanitizeLocation(id, base)
+	} else {
+		u, err = bs.ub.BuildBlobUploadChunkURL(bs.name, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := bs.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		location, err := sanitizeLocation(resp.Header.Get("Location"), u)
+		if err != nil {
+			return nil, err
+		}
+
+		rng := resp.Header.Get("Range")
+		var start, end int64
+		if n, err := fmt.Sscanf(rng, "%d-%d", &start, &end); err != nil {
+			return nil, err
+		} else if n != 2 || end < start {
+			return nil, fmt.Errorf("bad range format: %s", rng)
+		}
+
+		// The registry reports both an empty upload and a single stored
+		// byte as "0-0". Continuing from either guess could corrupt the
+		// blob, so the caller must restart the upload instead.
+		if end == 0 {
+			return nil, ErrBlobUploadOffsetAmbiguous
+		}
+
+		uuid := resp.Header.Get("Docker-Upload-UUID")
+		if uuid == "" {
+			uploadURL, err := url.Parse(u)
+			if err != nil {
+				return nil, err
+			}
+			uuid = path.Base(uploadURL.Path)
+		}
+
+		return &httpBlobUpload{
+			statter:   bs.statter,
+			client:    bs.client,
+			uuid:      uuid,
+			startedAt: time.Now(),
+			location:  location,
+			offset:    end + 1,
+		}, nil
+	case http.StatusNotFound:
+		return nil, distribution.ErrBlobUploadUnknown
+	default:
+		return nil, HandleErrorResponse(resp)
+	}
 }
 
 func (bs *blobs) Delete(ctx context.Context, dgst digest.Digest) error {
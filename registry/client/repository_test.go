@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"sort"
 	"strconv"
@@ -23,6 +24,7 @@ import (
 	"github.com/docker/distribution/reference"
 	"github.com/docker/distribution/registry/api/errcode"
 	"github.com/docker/distribution/registry/api/v2"
+	"github.com/docker/distribution/registry/client/transport"
 	"github.com/docker/distribution/testutil"
 	"github.com/docker/distribution/uuid"
 	"github.com/docker/libtrust"
@@ -159,6 +161,107 @@ func TestBlobFetch(t *testing.T) {
 	// TODO(dmcgowan): Test for unknown blob case
 }
 
+func TestBlobFetchResume(t *testing.T) {
+	d1, b1 := newRandomBlob(1024)
+	_, b2 := newRandomBlob(1024)
+	_, b3 := newRandomBlob(2048)
+	repo, _ := reference.WithName("test.example.com/repo1")
+	etag := `"` + d1.Hex() + `"`
+
+	for _, tc := range []struct {
+		name          string
+		supportsRange bool
+		// resumed is the content served after the connection is dropped.
+		resumed []byte
+		err     error
+	}{
+		{name: "range", supportsRange: true, resumed: b1},
+		{name: "no range", resumed: b1},
+		{name: "changed size", supportsRange: true, resumed: b3, err: transport.ErrContentChanged},
+		{name: "changed content", resumed: b2, err: distribution.ErrBlobInvalidDigest{Digest: d1}},
+	} {
+		var (
+			requests   []*http.Request
+			handlerErr error
+		)
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r)
+
+			if len(requests) == 1 {
+				// Drop the connection halfway through the first response.
+				w.Header().Set("Content-Length", fmt.Sprint(len(b1)))
+				w.Header().Set("ETag", etag)
+				w.WriteHeader(http.StatusOK)
+				w.Write(b1[:len(b1)/2])
+				w.(http.Flusher).Flush()
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					handlerErr = err
+					return
+				}
+				conn.Close()
+				return
+			}
+
+			content := tc.resumed
+			var start int
+			if rng := r.Header.Get("Range"); tc.supportsRange && rng != "" {
+				if _, err := fmt.Sscanf(rng, "bytes=%d-", &start); err != nil {
+					handlerErr = fmt.Errorf("unexpected range header %q: %v", rng, err)
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+				w.Header().Set("Content-Length", fmt.Sprint(len(content)-start))
+				w.WriteHeader(http.StatusPartialContent)
+			} else {
+				w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+				w.WriteHeader(http.StatusOK)
+			}
+			w.Write(content[start:])
+		}))
+
+		ctx := context.Background()
+		r, err := NewRepository(repo, s.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := r.Blobs(ctx).Get(ctx, d1)
+		s.Close()
+		if handlerErr != nil {
+			t.Fatalf("%s: %v", tc.name, handlerErr)
+		}
+		switch expected := tc.err.(type) {
+		case nil:
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if !bytes.Equal(b, b1) {
+				t.Fatalf("%s: wrong bytes values fetched: [%d]byte != [%d]byte", tc.name, len(b), len(b1))
+			}
+		case distribution.ErrBlobInvalidDigest:
+			if invalid, ok := err.(distribution.ErrBlobInvalidDigest); !ok || invalid.Digest != expected.Digest {
+				t.Fatalf("%s: expected ErrBlobInvalidDigest for %v, got %v", tc.name, expected.Digest, err)
+			}
+		default:
+			if err != tc.err {
+				t.Fatalf("%s: expected error %v, got %v", tc.name, tc.err, err)
+			}
+		}
+
+		if len(requests) != 2 {
+			t.Fatalf("%s: expected 2 requests, got %d", tc.name, len(requests))
+		}
+		if expected := fmt.Sprintf("bytes=%d-", len(b1)/2); requests[1].Header.Get("Range") != expected {
+			t.Fatalf("%s: unexpected range on resume: %q != %q", tc.name, requests[1].Header.Get("Range"), expected)
+		}
+		if ifRange := requests[1].Header.Get("If-Range"); ifRange != etag {
+			t.Fatalf("%s: unexpected If-Range on resume: %q != %q", tc.name, ifRange, etag)
+		}
+	}
+}
+
 func TestBlobExistsNoContentLength(t *testing.T) {
 	var m testutil.RequestResponseMap
 
@@ -365,6 +468,190 @@ func TestBlobUploadChunked(t *testing.T) {
 	}
 }
 
+func TestBlobUploadResume(t *testing.T) {
+	dgst, b1 := newRandomBlob(1024)
+	var m testutil.RequestResponseMap
+	repo, _ := reference.WithName("test.example.com/uploadrepo")
+	uploadID := uuid.Generate().String()
+	emptyID := uuid.Generate().String()
+
+	// The registry rejects requests for an upload without its state token,
+	// so each mapping requires the token handed out in the last location.
+	uploadRoute := "/v2/" + repo.Name() + "/blobs/uploads/" + uploadID
+	state := func(token string) map[string][]string {
+		return map[string][]string{"_state": {token}}
+	}
+
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method:      "GET",
+			Route:       uploadRoute,
+			QueryParams: state("first"),
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusNoContent,
+			Headers: http.Header(map[string][]string{
+				"Content-Length":     {"0"},
+				"Location":           {uploadRoute + "?_state=second"},
+				"Docker-Upload-UUID": {uploadID},
+				"Range":              {"0-511"},
+			}),
+		},
+	})
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method:      "PATCH",
+			Route:       uploadRoute,
+			QueryParams: state("second"),
+			Body:        b1[512:768],
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusAccepted,
+			Headers: http.Header(map[string][]string{
+				"Content-Length":     {"0"},
+				"Location":           {uploadRoute + "?_state=third"},
+				"Docker-Upload-UUID": {uploadID},
+				"Range":              {"0-767"},
+			}),
+		},
+	})
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method:      "PATCH",
+			Route:       uploadRoute,
+			QueryParams: state("third"),
+			Body:        b1[768:],
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusAccepted,
+			Headers: http.Header(map[string][]string{
+				"Content-Length":     {"0"},
+				"Location":           {uploadRoute + "?_state=fourth"},
+				"Docker-Upload-UUID": {uploadID},
+				"Range":              {"0-1023"},
+			}),
+		},
+	})
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method: "PUT",
+			Route:  uploadRoute,
+			QueryParams: map[string][]string{
+				"_state": {"fourth"},
+				"digest": {dgst.String()},
+			},
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusCreated,
+			Headers: http.Header(map[string][]string{
+				"Content-Length":        {"0"},
+				"Docker-Content-Digest": {dgst.String()},
+				"Content-Range":         {"0-1023"},
+			}),
+		},
+	})
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method: "HEAD",
+			Route:  "/v2/" + repo.Name() + "/blobs/" + dgst.String(),
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusOK,
+			Headers: http.Header(map[string][]string{
+				"Content-Length": {fmt.Sprint(len(b1))},
+				"Last-Modified":  {time.Now().Add(-1 * time.Second).Format(time.ANSIC)},
+			}),
+		},
+	})
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method:      "GET",
+			Route:       "/v2/" + repo.Name() + "/blobs/uploads/" + emptyID,
+			QueryParams: state("empty"),
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusNoContent,
+			Headers: http.Header(map[string][]string{
+				"Content-Length":     {"0"},
+				"Location":           {"/v2/" + repo.Name() + "/blobs/uploads/" + emptyID + "?_state=empty"},
+				"Docker-Upload-UUID": {emptyID},
+				"Range":              {"0-0"},
+			}),
+		},
+	})
+
+	e, c := testServer(m)
+	defer c()
+
+	ctx := context.Background()
+	r, err := NewRepository(repo, e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := r.Blobs(ctx)
+
+	if _, err := l.Resume(ctx, uuid.Generate().String()); err != distribution.ErrBlobUploadUnknown {
+		t.Fatalf("Expected ErrBlobUploadUnknown resuming unknown upload, got %v", err)
+	}
+
+	if _, err := l.Resume(ctx, "/v2/"+repo.Name()+"/blobs/uploads/"+emptyID+"?_state=empty"); err != ErrBlobUploadOffsetAmbiguous {
+		t.Fatalf("Expected ErrBlobUploadOffsetAmbiguous resuming upload with range 0-0, got %v", err)
+	}
+
+	if _, err := l.Resume(ctx, uploadRoute+"%zz?_state=first"); err == nil {
+		t.Fatal("Expected error resuming from unparseable location")
+	} else if _, ok := err.(*url.Error); !ok {
+		t.Fatalf("Expected url parse error resuming from unparseable location, got %v", err)
+	}
+
+	upload, err := l.Resume(ctx, uploadRoute+"?_state=first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if upload.ID() != uploadID {
+		t.Fatalf("Unexpected UUID %s; expected %s", upload.ID(), uploadID)
+	}
+	if upload.Size() != 512 {
+		t.Fatalf("Unexpected resumed offset: %d; expected: %d", upload.Size(), 512)
+	}
+
+	n, err := upload.Write(b1[512:768])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 256 {
+		t.Fatalf("Unexpected bytes written: %d; expected: %d", n, 256)
+	}
+	if upload.Size() != 768 {
+		t.Fatalf("Unexpected offset after write: %d; expected: %d", upload.Size(), 768)
+	}
+
+	// Hide WriterTo so that io.Copy finishes the upload with ReadFrom.
+	copied, err := io.Copy(upload, struct{ io.Reader }{bytes.NewReader(b1[768:])})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 256 {
+		t.Fatalf("Unexpected bytes copied: %d; expected: %d", copied, 256)
+	}
+	if upload.Size() != int64(len(b1)) {
+		t.Fatalf("Unexpected offset after write: %d; expected: %d", upload.Size(), len(b1))
+	}
+
+	blob, err := upload.Commit(ctx, distribution.Descriptor{
+		Digest: dgst,
+		Size:   int64(len(b1)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if blob.Size != int64(len(b1)) {
+		t.Fatalf("Unexpected blob size: %d; expected: %d", blob.Size, len(b1))
+	}
+}
+
 func TestBlobUploadMonolithic(t *testing.T) {
 	dgst, b1 := newRandomBlob(1024)
 	var m testutil.RequestResponseMap
@@ -817,6 +1104,68 @@ func TestManifestFetchWithEtag(t *testing.T) {
 	}
 }
 
+func TestManifestFetchTooLarge(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := reference.WithName("test.example.com/repo")
+	m1, dgst, _ := newRandomSchemaV1Manifest(repo, "latest", 6)
+	_, pl, err := m1.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m testutil.RequestResponseMap
+	addTestManifest(repo, dgst.String(), schema1.MediaTypeSignedManifest, pl, &m)
+	addTestManifest(repo, dgst.String(), schema1.MediaTypeSignedManifest, pl, &m)
+
+	e, c := testServer(m)
+	defer c()
+
+	r, err := NewRepository(repo, e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := r.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	limit := int64(len(pl) - 1)
+	_, err = ms.Get(ctx, dgst, WithMaxManifestSize(limit))
+	if tooLarge, ok := err.(*ManifestTooLargeError); !ok || tooLarge.Limit != limit {
+		t.Fatalf("expected ManifestTooLargeError with limit %d, got %v", limit, err)
+	}
+
+	manifest, err := ms.Get(ctx, dgst, WithMaxManifestSize(int64(len(pl))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEqualManifest(manifest.(*schema1.SignedManifest), m1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a Content-Length header the body must still be bounded.
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", schema1.MediaTypeSignedManifest)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		w.Write(pl)
+	}))
+	defer s.Close()
+
+	r, err = NewRepository(repo, s.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err = r.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ms.Get(ctx, dgst, WithMaxManifestSize(limit))
+	if _, ok := err.(*ManifestTooLargeError); !ok {
+		t.Fatalf("expected ManifestTooLargeError, got %v", err)
+	}
+}
+
 func TestManifestFetchWithAccept(t *testing.T) {
 	ctx := context.Background()
 	repo, _ := reference.WithName("test.example.com/repo")
@@ -981,6 +1330,83 @@ func TestManifestPut(t *testing.T) {
 	// TODO(dmcgowan): Check for invalid input error
 }
 
+func TestManifestPutEmptyLayers(t *testing.T) {
+	repo, _ := reference.WithName("test.example.com/repo/empty")
+	pk, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err := schema1.Sign(&schema1.Manifest{
+		Name:         repo.Name(),
+		Tag:          "empty",
+		Architecture: "x86",
+		Versioned: manifest.Versioned{
+			SchemaVersion: 1,
+		},
+	}, pk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, payload, err := sm.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromBytes(sm.Canonical)
+
+	var m testutil.RequestResponseMap
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method: "PUT",
+			Route:  "/v2/" + repo.Name() + "/manifests/empty",
+			Body:   payload,
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusAccepted,
+			Headers: http.Header(map[string][]string{
+				"Content-Length":        {"0"},
+				"Docker-Content-Digest": {dgst.String()},
+			}),
+		},
+	})
+
+	e, c := testServer(m)
+	defer c()
+
+	r, err := NewRepository(repo, e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ms, err := r.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ms.Put(ctx, sm, distribution.WithTag("empty")); err == nil {
+		t.Fatal("expected error putting manifest without layers")
+	} else if _, ok := err.(distribution.ErrManifestVerification); !ok {
+		t.Fatalf("expected ErrManifestVerification, got %T: %v", err, err)
+	}
+
+	emptyBlobSum, _, _ := newRandomSchemaV1Manifest(repo, "emptyblobsum", 2)
+	emptyBlobSum.FSLayers[1].BlobSum = ""
+	if _, err := ms.Put(ctx, emptyBlobSum, distribution.WithTag("emptyblobsum")); err == nil {
+		t.Fatal("expected error putting manifest with empty blob sum")
+	} else if _, ok := err.(distribution.ErrManifestVerification); !ok {
+		t.Fatalf("expected ErrManifestVerification, got %T: %v", err, err)
+	}
+
+	putDgst, err := ms.Put(ctx, sm, distribution.WithTag("empty"), AllowEmptyLayers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if putDgst != dgst {
+		t.Fatalf("unexpected digest: %s != %s", putDgst, dgst)
+	}
+}
+
 func TestManifestTags(t *testing.T) {
 	repo, _ := reference.WithName("test.example.com/repo/tags/list")
 	tagsList := []byte(strings.TrimSpace(`
@@ -1360,3 +1786,103 @@ func TestSanitizeLocation(t *testing.T) {
 		}
 	}
 }
+
+func TestNewRepositoryBaseURLValidation(t *testing.T) {
+	repo, _ := reference.WithName("test.example.com/repo1")
+	for _, testcase := range []struct {
+		baseURL string
+		valid   bool
+	}{
+		{baseURL: "https://registry.example.com", valid: true},
+		{baseURL: "http://localhost:5000", valid: true},
+		{baseURL: "ftp://registry.example.com"},
+		{baseURL: "registry.example.com"},
+		{baseURL: "https://"},
+		{baseURL: "://registry.example.com"},
+	} {
+		_, err := NewRepository(repo, testcase.baseURL, nil)
+		if testcase.valid && err != nil {
+			t.Fatalf("unexpected error for %q: %v", testcase.baseURL, err)
+		}
+		if !testcase.valid && err == nil {
+			t.Fatalf("expected error for %q", testcase.baseURL)
+		}
+
+		_, err = NewRegistry(testcase.baseURL, nil)
+		if testcase.valid && err != nil {
+			t.Fatalf("unexpected error for %q: %v", testcase.baseURL, err)
+		}
+		if !testcase.valid && err == nil {
+			t.Fatalf("expected error for %q", testcase.baseURL)
+		}
+	}
+}
+
+func TestManifestFetchRequireSignature(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := reference.WithName("test.example.com/repo")
+	m1, _, _ := newRandomSchemaV1Manifest(repo, "latest", 6)
+	_, signed, err := m1.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Altering the signed payload invalidates the signature without
+	// changing the length of the manifest.
+	tampered := bytes.Replace(signed, []byte(`"architecture": "x86"`), []byte(`"architecture": "arm"`), 1)
+	if bytes.Equal(tampered, signed) {
+		t.Fatal("failed to tamper with manifest")
+	}
+
+	// Stripping the signatures leaves an unsigned manifest.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(signed, &fields); err != nil {
+		t.Fatal(err)
+	}
+	fields["signatures"] = json.RawMessage("[]")
+	unsigned, err := json.MarshalIndent(fields, "", "   ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m testutil.RequestResponseMap
+	addTestManifestWithoutDigestHeader(repo, "signed", schema1.MediaTypeSignedManifest, signed, &m)
+	addTestManifestWithoutDigestHeader(repo, "tampered", schema1.MediaTypeSignedManifest, tampered, &m)
+	addTestManifestWithoutDigestHeader(repo, "tampered", schema1.MediaTypeSignedManifest, tampered, &m)
+	addTestManifestWithoutDigestHeader(repo, "unsigned", schema1.MediaTypeSignedManifest, unsigned, &m)
+
+	e, c := testServer(m)
+	defer c()
+
+	r, err := NewRepository(repo, e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := r.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := ms.Get(ctx, "", distribution.WithTag("signed"), RequireSignature())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkEqualManifest(manifest.(*schema1.SignedManifest), m1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ms.Get(ctx, "", distribution.WithTag("tampered")); err != nil {
+		t.Fatalf("unexpected error fetching tampered manifest without RequireSignature: %v", err)
+	}
+	if _, err := ms.Get(ctx, "", distribution.WithTag("tampered"), RequireSignature()); err == nil {
+		t.Fatal("expected tampered manifest to be rejected")
+	} else if _, ok := err.(distribution.ErrManifestVerification); !ok {
+		t.Fatalf("expected ErrManifestVerification for tampered manifest, got %v", err)
+	}
+
+	if _, err := ms.Get(ctx, "", distribution.WithTag("unsigned"), RequireSignature()); err == nil {
+		t.Fatal("expected unsigned manifest to be rejected")
+	} else if _, ok := err.(distribution.ErrManifestVerification); !ok {
+		t.Fatalf("expected ErrManifestVerification for unsigned manifest, got %v", err)
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 var (
@@ -16,8 +18,17 @@ var (
 	// with a Range header but the server returns a 2xx or 3xx code other
 	// than 206 Partial Content.
 	ErrWrongCodeForByteRange = errors.New("expected HTTP 206 from byte range request")
+
+	// ErrContentChanged is returned if a request made to continue reading
+	// reports a different total size than the first response, meaning the
+	// content changed between requests.
+	ErrContentChanged = errors.New("content changed between requests")
 )
 
+// maxReadResumes is the number of times a read interrupted by a connection
+// failure is resumed from the current offset before the error is returned.
+const maxReadResumes = 3
+
 // ReadSeekCloser combines io.ReadSeeker with io.Closer.
 type ReadSeekCloser interface {
 	io.ReadSeeker
@@ -46,6 +57,13 @@ type httpReadSeeker struct {
 	errorHandler func(*http.Response) error
 
 	size int64
+	// opened is set once a response has been received, after which size
+	// holds the total size of the content as first reported.
+	opened bool
+	// validator is the strong ETag or Last-Modified value of the first
+	// response, sent as If-Range so that range requests fail rather than
+	// splice in content which has changed.
+	validator string
 
 	// rc is the remote read closer.
 	rc io.ReadCloser
@@ -57,7 +75,12 @@ type httpReadSeeker struct {
 	// seek is undone (i.e. seeking to the end and then back to the
 	// beginning).
 	seekOffset int64
-	err        error
+	// resumes counts the reads resumed after a connection failure.
+	resumes int
+	// resuming is set while reopening the connection after a failure, in
+	// which case a server ignoring the range request is tolerated.
+	resuming bool
+	err      error
 }
 
 func (hrs *httpReadSeeker) Read(p []byte) (n int, err error) {
@@ -73,6 +96,7 @@ func (hrs *httpReadSeeker) Read(p []byte) (n int, err error) {
 	// back to the original position.
 	if hrs.readerOffset != hrs.seekOffset {
 		hrs.reset()
+		hrs.resuming = false
 	}
 
 	hrs.readerOffset = hrs.seekOffset
@@ -86,6 +110,18 @@ func (hrs *httpReadSeeker) Read(p []byte) (n int, err error) {
 	hrs.seekOffset += int64(n)
 	hrs.readerOffset += int64(n)
 
+	if err != nil && err != io.EOF && hrs.resumes < maxReadResumes {
+		// The connection failed mid-read. Drop it so the next read
+		// resumes from the current offset with a range request.
+		hrs.reset()
+		hrs.resumes++
+		hrs.resuming = true
+		if n > 0 {
+			return n, nil
+		}
+		return hrs.Read(p)
+	}
+
 	return n, err
 }
 
@@ -178,6 +214,9 @@ func (hrs *httpReadSeeker) reader() (io.Reader, error) {
 		req.Header.Add("Range", fmt.Sprintf("bytes=%d-", hrs.readerOffset))
 		// TODO: get context in here
 		// context.GetLogger(hrs.context).Infof("Range: %s", req.Header.Get("Range"))
+		if hrs.validator != "" {
+			req.Header.Add("If-Range", hrs.validator)
+		}
 	}
 
 	req.Header.Add("Accept-Encoding", "identity")
@@ -189,8 +228,21 @@ func (hrs *httpReadSeeker) reader() (io.Reader, error) {
 	// Normally would use client.SuccessStatus, but that would be a cyclic
 	// import
 	if resp.StatusCode >= 200 && resp.StatusCode <= 399 {
-		if hrs.readerOffset > 0 {
+		if hrs.readerOffset > 0 && hrs.resuming && resp.StatusCode == http.StatusOK {
+			// The server ignored the range request while resuming an
+			// interrupted read, so skip the content already read.
+			if err := hrs.checkSize(resp.ContentLength); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, hrs.readerOffset); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+			hrs.size = resp.ContentLength
+		} else if hrs.readerOffset > 0 {
 			if resp.StatusCode != http.StatusPartialContent {
+				resp.Body.Close()
 				return nil, ErrWrongCodeForByteRange
 			}
 
@@ -219,6 +271,10 @@ func (hrs *httpReadSeeker) reader() (io.Reader, error) {
 			}
 
 			if submatches[3] == "*" {
+				if err := hrs.checkSize(-1); err != nil {
+					resp.Body.Close()
+					return nil, err
+				}
 				hrs.size = -1
 			} else {
 				size, err := strconv.ParseUint(submatches[3], 10, 64)
@@ -230,14 +286,27 @@ func (hrs *httpReadSeeker) reader() (io.Reader, error) {
 					return nil, fmt.Errorf("range in Content-Range stops before the end of the content: %s", contentRange)
 				}
 
+				if err := hrs.checkSize(int64(size)); err != nil {
+					resp.Body.Close()
+					return nil, err
+				}
 				hrs.size = int64(size)
 			}
 		} else if resp.StatusCode == http.StatusOK {
+			if err := hrs.checkSize(resp.ContentLength); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
 			hrs.size = resp.ContentLength
 		} else {
 			hrs.size = -1
 		}
+		if !hrs.opened {
+			hrs.opened = true
+			hrs.validator = responseValidator(resp)
+		}
 		hrs.rc = resp.Body
+		hrs.resuming = false
 	} else {
 		defer resp.Body.Close()
 		if hrs.errorHandler != nil {
@@ -248,3 +317,22 @@ func (hrs *httpReadSeeker) reader() (io.Reader, error) {
 
 	return hrs.rc, nil
 }
+
+// checkSize returns ErrContentChanged if a response reports a total size
+// other than the one first seen for the content.
+func (hrs *httpReadSeeker) checkSize(size int64) error {
+	if hrs.opened && hrs.size >= 0 && size != hrs.size {
+		return ErrContentChanged
+	}
+	return nil
+}
+
+// responseValidator returns a value suitable for an If-Range header
+// identifying the content of the response. Weak ETags cannot be used with
+// If-Range, so Last-Modified is used instead.
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
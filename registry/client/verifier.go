@@ -0,0 +1,92 @@
+package client
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// NewVerifyingReader returns a reader which verifies the content read from r
+// against the expected digest. When r is exhausted, Read returns
+// distribution.ErrBlobInvalidDigest instead of io.EOF if the content does not
+// match.
+func NewVerifyingReader(r io.Reader, expected digest.Digest) io.Reader {
+	if err := expected.Validate(); err != nil {
+		return &verifyingReader{err: distribution.ErrBlobInvalidDigest{Digest: expected, Reason: err}}
+	}
+
+	return &verifyingReader{
+		reader:   r,
+		verifier: expected.Verifier(),
+		expected: expected,
+	}
+}
+
+type verifyingReader struct {
+	reader   io.Reader
+	verifier digest.Verifier
+	expected digest.Digest
+	err      error
+}
+
+func (vr *verifyingReader) Read(p []byte) (int, error) {
+	if vr.err != nil {
+		return 0, vr.err
+	}
+
+	n, err := vr.reader.Read(p)
+	if n > 0 {
+		vr.verifier.Write(p[:n])
+	}
+
+	if err == io.EOF {
+		if !vr.verifier.Verified() {
+			err = distribution.ErrBlobInvalidDigest{
+				Digest: vr.expected,
+				Reason: fmt.Errorf("content does not match digest"),
+			}
+		}
+		vr.err = err
+	} else if err != nil {
+		vr.err = err
+	}
+
+	return n, err
+}
+
+// verifyingReadSeeker verifies blob content against its digest while it is
+// read sequentially from the start. Once a seek moves away from the verified
+// position, the content read no longer covers the whole blob and is passed
+// through unverified.
+type verifyingReadSeeker struct {
+	distribution.ReadSeekCloser
+	verifier io.Reader
+	offset   int64
+}
+
+func newVerifyingReadSeeker(rsc distribution.ReadSeekCloser, expected digest.Digest) distribution.ReadSeekCloser {
+	return &verifyingReadSeeker{
+		ReadSeekCloser: rsc,
+		verifier:       NewVerifyingReader(rsc, expected),
+	}
+}
+
+func (vrs *verifyingReadSeeker) Read(p []byte) (int, error) {
+	if vrs.verifier == nil {
+		return vrs.ReadSeekCloser.Read(p)
+	}
+
+	n, err := vrs.verifier.Read(p)
+	vrs.offset += int64(n)
+	return n, err
+}
+
+func (vrs *verifyingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	n, err := vrs.ReadSeekCloser.Seek(offset, whence)
+	if err == nil && n != vrs.offset {
+		vrs.verifier = nil
+	}
+	return n, err
+}
@@ -0,0 +1,44 @@
+package client
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestVerifyingReader(t *testing.T) {
+	dgst, b := newRandomBlob(1024)
+
+	p, err := ioutil.ReadAll(NewVerifyingReader(bytes.NewReader(b), dgst))
+	if err != nil {
+		t.Fatalf("unexpected error reading matching content: %v", err)
+	}
+	if !bytes.Equal(p, b) {
+		t.Fatalf("content was altered by verifying reader")
+	}
+
+	tampered := append([]byte{}, b...)
+	tampered[0] ^= 0xff
+	r := NewVerifyingReader(bytes.NewReader(tampered), dgst)
+	_, err = io.Copy(ioutil.Discard, r)
+	if invalid, ok := err.(distribution.ErrBlobInvalidDigest); !ok || invalid.Digest != dgst {
+		t.Fatalf("expected ErrBlobInvalidDigest for %v, got %v", dgst, err)
+	}
+	if _, err := r.Read(make([]byte, 1)); err == nil || err == io.EOF {
+		t.Fatalf("expected mismatch error to be sticky, got %v", err)
+	}
+
+	_, err = io.Copy(ioutil.Discard, NewVerifyingReader(bytes.NewReader(b[:512]), dgst))
+	if _, ok := err.(distribution.ErrBlobInvalidDigest); !ok {
+		t.Fatalf("expected ErrBlobInvalidDigest for truncated content, got %v", err)
+	}
+
+	_, err = io.Copy(ioutil.Discard, NewVerifyingReader(bytes.NewReader(b), digest.Digest("sha256:invalid")))
+	if _, ok := err.(distribution.ErrBlobInvalidDigest); !ok {
+		t.Fatalf("expected ErrBlobInvalidDigest for invalid digest, got %v", err)
+	}
+}
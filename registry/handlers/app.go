@@ -30,6 +30,7 @@ import (
 	repositorymiddleware "github.com/docker/distribution/registry/middleware/repository"
 	"github.com/docker/distribution/registry/proxy"
 	"github.com/docker/distribution/registry/storage"
+	"github.com/docker/distribution/registry/storage/cache"
 	memorycache "github.com/docker/distribution/registry/storage/cache/memory"
 	rediscache "github.com/docker/distribution/registry/storage/cache/redis"
 	storagedriver "github.com/docker/distribution/registry/storage/driver"
@@ -265,6 +266,10 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 			v = cc["layerinfo"]
 		}
 
+		if breaker, ok := blobDescriptorCacheBreaker(cc); ok {
+			options = append(options, storage.BlobDescriptorCacheBreaker(breaker))
+		}
+
 		switch v {
 		case "redis":
 			if app.redis == nil {
@@ -1007,6 +1012,37 @@ func uploadPurgeDefaultConfig() map[interface{}]interface{} {
 	return config
 }
 
+// blobDescriptorCacheBreaker parses the optional breakerthreshold and
+// breakercooldown parameters of the cache configuration.
+func blobDescriptorCacheBreaker(cc configuration.Parameters) (cache.BreakerConfig, bool) {
+	v, ok := cc["breakerthreshold"]
+	if !ok {
+		return cache.BreakerConfig{}, false
+	}
+	threshold, ok := v.(int)
+	if !ok || threshold < 0 {
+		panic(fmt.Sprintf("breakerthreshold must be a non-negative integer: %v", v))
+	}
+
+	cooldown := 30 * time.Second
+	if v, ok := cc["breakercooldown"]; ok {
+		cooldownStr, ok := v.(string)
+		if !ok {
+			panic(fmt.Sprintf("breakercooldown must be a duration string: %v", v))
+		}
+		var err error
+		cooldown, err = time.ParseDuration(cooldownStr)
+		if err != nil {
+			panic(fmt.Sprintf("cannot parse breakercooldown: %v", err))
+		}
+	}
+
+	return cache.BreakerConfig{
+		Threshold: threshold,
+		Cooldown:  cooldown,
+	}, true
+}
+
 func badPurgeUploadConfig(reason string) {
 	panic(fmt.Sprintf("Unable to parse upload purge configuration: %s", reason))
 }
@@ -34,10 +34,12 @@ func (bsc *blobStatCollector) Logger(ctx context.Context) cache.Logger {
 // blobStatterCacheMetrics keeps track of cache metrics for blob descriptor
 // cache requests. Note this is kept globally and made available via expvar.
 // For more detailed metrics, its recommend to instrument a particular cache
-// implementation.
+// implementation. The counters are also exposed through prometheus.
 var blobStatterCacheMetrics cache.MetricsTracker = &blobStatCollector{}
 
 func init() {
+	cache.RegisterMetricsTracker("blobdescriptor", blobStatterCacheMetrics)
+
 	registry := expvar.Get("registry")
 	if registry == nil {
 		registry = expvar.NewMap("registry")
@@ -2,6 +2,8 @@ package cache
 
 import (
 	"context"
+	"sync"
+	"time"
 
 	"github.com/docker/distribution"
 	prometheus "github.com/docker/distribution/metrics"
@@ -33,15 +35,33 @@ type MetricsTracker interface {
 	Logger(context.Context) Logger
 }
 
+// BreakerConfig controls when a cached statter stops attempting to write
+// descriptors to an unhealthy cache.
+type BreakerConfig struct {
+	// Threshold is the number of consecutive cache write failures after
+	// which writes are suspended. A zero value disables the breaker.
+	Threshold int
+
+	// Cooldown is how long cache writes remain suspended once the
+	// threshold has been reached.
+	Cooldown time.Duration
+}
+
 type cachedBlobStatter struct {
 	cache   distribution.BlobDescriptorService
 	backend distribution.BlobDescriptorService
 	tracker MetricsTracker
+	writes  *WriteGuard
 }
 
 var (
 	// cacheCount is the number of total cache request received/hits/misses
 	cacheCount = prometheus.StorageNamespace.NewLabeledCounter("cache", "The number of cache request received", "type")
+
+	// cacheErrorLogInterval is the minimum interval between logged cache
+	// write failures. Failures in between are counted and reported with
+	// the next logged error.
+	cacheErrorLogInterval = 10 * time.Second
 )
 
 // NewCachedBlobStatter creates a new statter which prefers a cache and
@@ -50,6 +70,7 @@ func NewCachedBlobStatter(cache distribution.BlobDescriptorService, backend dist
 	return &cachedBlobStatter{
 		cache:   cache,
 		backend: backend,
+		writes:  NewWriteGuard(BreakerConfig{}),
 	}
 }
 
@@ -60,11 +81,63 @@ func NewCachedBlobStatterWithMetrics(cache distribution.BlobDescriptorService, b
 		cache:   cache,
 		backend: backend,
 		tracker: tracker,
+		writes:  NewWriteGuard(BreakerConfig{}),
+	}
+}
+
+// NewCachedBlobStatterWithWriteGuard creates a new statter which prefers a
+// cache and falls back to a backend. Hits and misses will send to the
+// tracker. Writes to the cache go through the guard, which should be shared
+// by all statters using the same cache so that failures are rate limited and
+// counted across them.
+func NewCachedBlobStatterWithWriteGuard(cache distribution.BlobDescriptorService, backend distribution.BlobDescriptorService, tracker MetricsTracker, guard *WriteGuard) distribution.BlobDescriptorService {
+	return &cachedBlobStatter{
+		cache:   cache,
+		backend: backend,
+		tracker: tracker,
+		writes:  guard,
+	}
+}
+
+type bypassCacheKey struct{}
+
+// WithCacheBypass returns a context which causes cached statters to ignore
+// any cached descriptor for the given digests, consulting the backend and
+// refreshing the cache instead. If no digests are given, the cache is
+// bypassed for every digest.
+func WithCacheBypass(ctx context.Context, dgsts ...digest.Digest) context.Context {
+	bypass := make(map[digest.Digest]struct{}, len(dgsts))
+	for _, dgst := range dgsts {
+		bypass[dgst] = struct{}{}
 	}
+	return context.WithValue(ctx, bypassCacheKey{}, bypass)
+}
+
+// bypassCache reports whether the context requests the cache to be
+// bypassed for the digest.
+func bypassCache(ctx context.Context, dgst digest.Digest) bool {
+	bypass, ok := ctx.Value(bypassCacheKey{}).(map[digest.Digest]struct{})
+	if !ok {
+		return false
+	}
+	if len(bypass) == 0 {
+		return true
+	}
+	_, ok = bypass[dgst]
+	return ok
 }
 
 func (cbds *cachedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
 	cacheCount.WithValues("Request").Inc(1)
+	if bypassCache(ctx, dgst) {
+		// The cache is not consulted, so the request counts as a miss.
+		cacheCount.WithValues("Miss").Inc(1)
+		if cbds.tracker != nil {
+			cbds.tracker.Miss()
+		}
+		return cbds.refresh(ctx, dgst)
+	}
+
 	desc, err := cbds.cache.Stat(ctx, dgst)
 	if err != nil {
 		if err != distribution.ErrBlobUnknown {
@@ -88,14 +161,31 @@ fallback:
 		return desc, err
 	}
 
-	if err := cbds.cache.SetDescriptor(ctx, dgst, desc); err != nil {
-		logErrorf(ctx, cbds.tracker, "error adding descriptor %v to cache: %v", desc.Digest, err)
-	}
+	cbds.setCacheDescriptor(ctx, dgst, desc)
 
 	return desc, err
 
 }
 
+// refresh stats the digest on the backend, replacing any cached descriptor
+// with the result. Stale entries are removed from the cache when the backend
+// no longer knows the blob.
+func (cbds *cachedBlobStatter) refresh(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	desc, err := cbds.backend.Stat(ctx, dgst)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown {
+			if err := cbds.cache.Clear(ctx, dgst); err != nil && err != distribution.ErrBlobUnknown {
+				logErrorf(ctx, cbds.tracker, "error clearing descriptor %v from cache: %v", dgst, err)
+			}
+		}
+		return desc, err
+	}
+
+	cbds.setCacheDescriptor(ctx, dgst, desc)
+
+	return desc, nil
+}
+
 func (cbds *cachedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) error {
 	err := cbds.cache.Clear(ctx, dgst)
 	if err != nil {
@@ -110,10 +200,99 @@ func (cbds *cachedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) er
 }
 
 func (cbds *cachedBlobStatter) SetDescriptor(ctx context.Context, dgst digest.Digest, desc distribution.Descriptor) error {
+	cbds.setCacheDescriptor(ctx, dgst, desc)
+	return nil
+}
+
+// setCacheDescriptor writes the descriptor to the cache unless writes have
+// been suspended after repeated failures. Errors are logged, not returned.
+func (cbds *cachedBlobStatter) setCacheDescriptor(ctx context.Context, dgst digest.Digest, desc distribution.Descriptor) {
+	if !cbds.writes.allow() {
+		return
+	}
+
 	if err := cbds.cache.SetDescriptor(ctx, dgst, desc); err != nil {
-		logErrorf(ctx, cbds.tracker, "error adding descriptor %v to cache: %v", desc.Digest, err)
+		cbds.writes.failed(ctx, cbds.tracker, desc.Digest, err)
+		return
+	}
+	cbds.writes.succeeded()
+}
+
+// WriteGuard rate limits the logging of cache write failures and optionally
+// suspends cache writes after consecutive failures. It is safe for
+// concurrent use.
+type WriteGuard struct {
+	mu         sync.Mutex
+	threshold  int
+	cooldown   time.Duration
+	failures   int
+	openUntil  time.Time
+	lastLogged time.Time
+	suppressed int
+}
+
+// NewWriteGuard returns a WriteGuard which suspends cache writes according to
+// the breaker configuration.
+func NewWriteGuard(config BreakerConfig) *WriteGuard {
+	return &WriteGuard{
+		threshold: config.Threshold,
+		cooldown:  config.Cooldown,
+	}
+}
+
+// allow reports whether a cache write should be attempted.
+func (g *WriteGuard) allow() bool {
+	if g.threshold <= 0 {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return !time.Now().Before(g.openUntil)
+}
+
+func (g *WriteGuard) succeeded() {
+	g.mu.Lock()
+	g.failures = 0
+	g.mu.Unlock()
+}
+
+func (g *WriteGuard) failed(ctx context.Context, tracker MetricsTracker, dgst digest.Digest, err error) {
+	// Decide what to log while holding the lock, but log after releasing it
+	// so that concurrent statters do not wait on the logger.
+	var (
+		format string
+		args   []interface{}
+	)
+
+	g.mu.Lock()
+	now := time.Now()
+	g.failures++
+	switch {
+	case g.threshold > 0 && g.failures >= g.threshold:
+		format = "suspending descriptor cache writes for %v after %d consecutive failures: %v"
+		args = []interface{}{g.cooldown, g.failures, err}
+		g.openUntil = now.Add(g.cooldown)
+		g.failures = 0
+		g.lastLogged = now
+		g.suppressed = 0
+	case now.Sub(g.lastLogged) < cacheErrorLogInterval:
+		g.suppressed++
+	case g.suppressed > 0:
+		format = "error adding descriptor %v to cache: %v (%d similar errors suppressed)"
+		args = []interface{}{dgst, err, g.suppressed}
+		g.lastLogged = now
+		g.suppressed = 0
+	default:
+		format = "error adding descriptor %v to cache: %v"
+		args = []interface{}{dgst, err}
+		g.lastLogged = now
+	}
+	g.mu.Unlock()
+
+	if format != "" {
+		logErrorf(ctx, tracker, format, args...)
 	}
-	return nil
 }
 
 func logErrorf(ctx context.Context, tracker MetricsTracker, format string, args ...interface{}) {
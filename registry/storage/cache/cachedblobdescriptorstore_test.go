@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// testDescriptorService is a BlobDescriptorService backed by a map which
+// can be made to fail writes.
+type testDescriptorService struct {
+	descriptors map[digest.Digest]distribution.Descriptor
+	setErr      error
+	stats       int
+	sets        int
+}
+
+func newTestDescriptorService() *testDescriptorService {
+	return &testDescriptorService{
+		descriptors: make(map[digest.Digest]distribution.Descriptor),
+	}
+}
+
+func (s *testDescriptorService) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	s.stats++
+	desc, ok := s.descriptors[dgst]
+	if !ok {
+		return distribution.Descriptor{}, distribution.ErrBlobUnknown
+	}
+	return desc, nil
+}
+
+func (s *testDescriptorService) SetDescriptor(ctx context.Context, dgst digest.Digest, desc distribution.Descriptor) error {
+	s.sets++
+	if s.setErr != nil {
+		return s.setErr
+	}
+	s.descriptors[dgst] = desc
+	return nil
+}
+
+func (s *testDescriptorService) Clear(ctx context.Context, dgst digest.Digest) error {
+	delete(s.descriptors, dgst)
+	return nil
+}
+
+type testLogger struct {
+	messages []string
+}
+
+func (l *testLogger) Errorf(format string, args ...interface{}) {
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+type loggingMetricsTracker struct {
+	testMetricsTracker
+	logger *testLogger
+}
+
+func (t *loggingMetricsTracker) Logger(context.Context) Logger {
+	return t.logger
+}
+
+func TestCachedBlobStatterBreaker(t *testing.T) {
+	ctx := context.Background()
+	dgst := digest.FromString("breaker")
+	desc := distribution.Descriptor{
+		Digest:    dgst,
+		Size:      7,
+		MediaType: "application/octet-stream",
+	}
+
+	cache := newTestDescriptorService()
+	cache.setErr = errors.New("cache is full")
+	backend := newTestDescriptorService()
+	backend.descriptors[dgst] = desc
+
+	tracker := &loggingMetricsTracker{logger: &testLogger{}}
+	guard := NewWriteGuard(BreakerConfig{
+		Threshold: 3,
+		Cooldown:  50 * time.Millisecond,
+	})
+
+	// Statters are created per request, so failures must be counted across
+	// statters sharing a guard.
+	for i := 0; i < 5; i++ {
+		statter := NewCachedBlobStatterWithWriteGuard(cache, backend, tracker, guard)
+		if _, err := statter.Stat(ctx, dgst); err != nil {
+			t.Fatalf("unexpected error from stat: %v", err)
+		}
+	}
+	statter := NewCachedBlobStatterWithWriteGuard(cache, backend, tracker, guard)
+
+	if cache.sets != 3 {
+		t.Fatalf("expected cache writes to stop after 3 failures, got %d writes", cache.sets)
+	}
+	if backend.stats != 5 {
+		t.Fatalf("expected every stat to reach the backend, got %d", backend.stats)
+	}
+	// The first failure is logged, the second is suppressed by the rate
+	// limit and the third opens the breaker.
+	if len(tracker.logger.messages) != 2 {
+		t.Fatalf("unexpected log messages: %q", tracker.logger.messages)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	cache.setErr = nil
+	if _, err := statter.Stat(ctx, dgst); err != nil {
+		t.Fatalf("unexpected error from stat: %v", err)
+	}
+	if cache.sets != 4 {
+		t.Fatalf("expected cache writes to resume after cooldown, got %d writes", cache.sets)
+	}
+
+	if _, err := statter.Stat(ctx, dgst); err != nil {
+		t.Fatalf("unexpected error from stat: %v", err)
+	}
+	if backend.stats != 6 {
+		t.Fatalf("expected stat to be served from cache, backend saw %d stats", backend.stats)
+	}
+}
+
+func TestCachedBlobStatterBypass(t *testing.T) {
+	ctx := context.Background()
+	dgst := digest.FromString("bypass")
+	other := digest.FromString("other")
+
+	stale := distribution.Descriptor{
+		Digest:    dgst,
+		Size:      1,
+		MediaType: "application/octet-stream",
+	}
+	fresh := stale
+	fresh.Size = 2
+
+	cache := newTestDescriptorService()
+	cache.descriptors[dgst] = stale
+	backend := newTestDescriptorService()
+	backend.descriptors[dgst] = fresh
+
+	tracker := &testMetricsTracker{}
+	statter := NewCachedBlobStatterWithMetrics(cache, backend, tracker)
+
+	desc, err := statter.Stat(ctx, dgst)
+	if err != nil {
+		t.Fatalf("unexpected error from stat: %v", err)
+	}
+	if desc.Size != stale.Size || backend.stats != 0 {
+		t.Fatalf("expected cached descriptor without backend stat, got %v after %d backend stats", desc, backend.stats)
+	}
+
+	// Bypassing a different digest must not affect this one.
+	desc, err = statter.Stat(WithCacheBypass(ctx, other), dgst)
+	if err != nil {
+		t.Fatalf("unexpected error from stat: %v", err)
+	}
+	if desc.Size != stale.Size || backend.stats != 0 {
+		t.Fatalf("expected cached descriptor without backend stat, got %v after %d backend stats", desc, backend.stats)
+	}
+
+	desc, err = statter.Stat(WithCacheBypass(ctx, dgst), dgst)
+	if err != nil {
+		t.Fatalf("unexpected error from stat: %v", err)
+	}
+	if desc.Size != fresh.Size || backend.stats != 1 {
+		t.Fatalf("expected backend descriptor, got %v after %d backend stats", desc, backend.stats)
+	}
+	if cache.descriptors[dgst].Size != fresh.Size {
+		t.Fatalf("expected cache to be refreshed, got %v", cache.descriptors[dgst])
+	}
+
+	// A bypass for a blob the backend no longer has removes the stale
+	// cache entry.
+	delete(backend.descriptors, dgst)
+	if _, err := statter.Stat(WithCacheBypass(ctx), dgst); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected ErrBlobUnknown, got %v", err)
+	}
+	if _, ok := cache.descriptors[dgst]; ok {
+		t.Fatalf("expected stale descriptor to be cleared from cache")
+	}
+
+	// Bypassed requests are counted as misses.
+	if metrics := tracker.Metrics(); metrics.Requests != 4 || metrics.Hits != 2 || metrics.Misses != 2 {
+		t.Fatalf("unexpected metrics: %#v", metrics)
+	}
+}
@@ -0,0 +1,315 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// defaultMaxCachedManifests bounds the number of manifests, and separately
+// of tags, held by a cached manifest service.
+const defaultMaxCachedManifests = 1024
+
+type cachedManifestService struct {
+	backend    distribution.ManifestService
+	tags       distribution.TagService
+	ttl        time.Duration
+	tracker    MetricsTracker
+	maxEntries int
+
+	mu        sync.Mutex
+	manifests map[digest.Digest]cachedManifest
+	tagged    map[string]cachedTag
+	// fetches holds the backend requests in progress, keyed by digest or
+	// tag, so that concurrent misses share a single request.
+	fetches map[string]*fetch
+}
+
+// fetch is a backend request shared by concurrent callers.
+type fetch struct {
+	done   chan struct{}
+	result interface{}
+	err    error
+}
+
+type cachedManifest struct {
+	manifest distribution.Manifest
+	expires  time.Time
+}
+
+type cachedTag struct {
+	digest  digest.Digest
+	expires time.Time
+}
+
+// NewCachedManifestService creates a manifest service which caches manifests
+// fetched from the backend for the given ttl. When a manifest is requested by
+// tag, the tag is resolved to a digest with the tag service and the mapping
+// is cached for the same ttl. Once it expires the tag is resolved again: a
+// changed digest causes the new manifest to be fetched, while a cached
+// manifest matching the current digest is revalidated and kept. Hits and
+// misses will send to the tracker.
+//
+// At most 1024 manifests and 1024 tags are cached. Expired entries are
+// swept when the cache is full, followed by the entries closest to expiring.
+// Concurrent misses for the same digest or tag share one backend request.
+func NewCachedManifestService(backend distribution.ManifestService, tags distribution.TagService, ttl time.Duration, tracker MetricsTracker) distribution.ManifestService {
+	return &cachedManifestService{
+		backend:    backend,
+		tags:       tags,
+		ttl:        ttl,
+		tracker:    tracker,
+		maxEntries: defaultMaxCachedManifests,
+		manifests:  make(map[digest.Digest]cachedManifest),
+		tagged:     make(map[string]cachedTag),
+		fetches:    make(map[string]*fetch),
+	}
+}
+
+func (cms *cachedManifestService) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	if _, ok := cms.lookup(dgst); ok {
+		return true, nil
+	}
+	return cms.backend.Exists(ctx, dgst)
+}
+
+// Get returns the manifest from the cache if possible. Options other than
+// distribution.WithTag may alter the response, so requests carrying them are
+// passed to the backend without consulting the cache.
+func (cms *cachedManifestService) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	var tag string
+	for _, option := range options {
+		opt, ok := option.(distribution.WithTagOption)
+		if !ok {
+			return cms.backend.Get(ctx, dgst, options...)
+		}
+		tag = opt.Tag
+	}
+
+	if tag != "" {
+		resolved, err := cms.resolveTag(ctx, tag)
+		if err != nil {
+			// Let the backend report the failure for the tag.
+			cms.miss()
+			return cms.backend.Get(ctx, dgst, options...)
+		}
+		dgst = resolved
+	}
+
+	if m, ok := cms.lookup(dgst); ok {
+		if cms.tracker != nil {
+			cms.tracker.Hit()
+		}
+		return m, nil
+	}
+	cms.miss()
+
+	// Tagged manifests are fetched by their resolved digest so that the
+	// cached content always matches the digest it is stored under.
+	m, err := cms.do(ctx, "digest:"+dgst.String(), func(ctx context.Context) (interface{}, error) {
+		m, err := cms.backend.Get(ctx, dgst)
+		if err != nil {
+			return nil, err
+		}
+
+		cms.mu.Lock()
+		cms.evictManifests()
+		cms.manifests[dgst] = cachedManifest{
+			manifest: m,
+			expires:  time.Now().Add(cms.ttl),
+		}
+		cms.mu.Unlock()
+
+		return m, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m.(distribution.Manifest), nil
+}
+
+func (cms *cachedManifestService) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	dgst, err := cms.backend.Put(ctx, manifest, options...)
+	if err != nil {
+		return dgst, err
+	}
+
+	cms.mu.Lock()
+	for _, option := range options {
+		if opt, ok := option.(distribution.WithTagOption); ok {
+			delete(cms.tagged, opt.Tag)
+		}
+	}
+	cms.mu.Unlock()
+
+	return dgst, nil
+}
+
+func (cms *cachedManifestService) Delete(ctx context.Context, dgst digest.Digest) error {
+	cms.mu.Lock()
+	delete(cms.manifests, dgst)
+	for tag, entry := range cms.tagged {
+		if entry.digest == dgst {
+			delete(cms.tagged, tag)
+		}
+	}
+	cms.mu.Unlock()
+
+	return cms.backend.Delete(ctx, dgst)
+}
+
+// resolveTag returns the digest the tag refers to, consulting the tag
+// service when no unexpired mapping is cached.
+func (cms *cachedManifestService) resolveTag(ctx context.Context, tag string) (digest.Digest, error) {
+	cms.mu.Lock()
+	entry, ok := cms.tagged[tag]
+	cms.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.digest, nil
+	}
+
+	dgst, err := cms.do(ctx, "tag:"+tag, func(ctx context.Context) (interface{}, error) {
+		desc, err := cms.tags.Get(ctx, tag)
+		if err != nil {
+			return nil, err
+		}
+
+		expires := time.Now().Add(cms.ttl)
+
+		cms.mu.Lock()
+		cms.evictTags()
+		cms.tagged[tag] = cachedTag{
+			digest:  desc.Digest,
+			expires: expires,
+		}
+		// A cached manifest with the digest the tag still refers to has
+		// been revalidated, so it may be served until the tag is checked
+		// again.
+		if cached, ok := cms.manifests[desc.Digest]; ok {
+			cached.expires = expires
+			cms.manifests[desc.Digest] = cached
+		}
+		cms.mu.Unlock()
+
+		return desc.Digest, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return dgst.(digest.Digest), nil
+}
+
+// do calls fn unless a call for the same key is already in progress, in
+// which case it shares the result of that call. The call runs in its own
+// goroutine on a context detached from any caller's cancellation, so each
+// caller stops waiting when its own ctx is done without failing the others.
+func (cms *cachedManifestService) do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	cms.mu.Lock()
+	f, ok := cms.fetches[key]
+	if !ok {
+		f = &fetch{done: make(chan struct{})}
+		cms.fetches[key] = f
+
+		go func() {
+			f.result, f.err = fn(detachedContext{ctx})
+
+			cms.mu.Lock()
+			delete(cms.fetches, key)
+			cms.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	cms.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.result, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// detachedContext carries the values of its parent but is never cancelled.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// evictManifests makes room for a manifest when the cache is full. It must
+// be called with mu held.
+func (cms *cachedManifestService) evictManifests() {
+	if len(cms.manifests) < cms.maxEntries {
+		return
+	}
+
+	now := time.Now()
+	var (
+		oldest  digest.Digest
+		expires time.Time
+	)
+	for dgst, entry := range cms.manifests {
+		if !now.Before(entry.expires) {
+			delete(cms.manifests, dgst)
+		} else if oldest == "" || entry.expires.Before(expires) {
+			oldest, expires = dgst, entry.expires
+		}
+	}
+	if len(cms.manifests) >= cms.maxEntries {
+		delete(cms.manifests, oldest)
+	}
+}
+
+// evictTags makes room for a tag when the cache is full. It must be called
+// with mu held.
+func (cms *cachedManifestService) evictTags() {
+	if len(cms.tagged) < cms.maxEntries {
+		return
+	}
+
+	now := time.Now()
+	var (
+		oldest  string
+		expires time.Time
+	)
+	for tag, entry := range cms.tagged {
+		if !now.Before(entry.expires) {
+			delete(cms.tagged, tag)
+		} else if oldest == "" || entry.expires.Before(expires) {
+			oldest, expires = tag, entry.expires
+		}
+	}
+	if len(cms.tagged) >= cms.maxEntries {
+		delete(cms.tagged, oldest)
+	}
+}
+
+// lookup returns the cached manifest for the digest, evicting it if it has
+// expired.
+func (cms *cachedManifestService) lookup(dgst digest.Digest) (distribution.Manifest, bool) {
+	cms.mu.Lock()
+	defer cms.mu.Unlock()
+
+	entry, ok := cms.manifests[dgst]
+	if !ok {
+		return nil, false
+	}
+	if !time.Now().Before(entry.expires) {
+		delete(cms.manifests, dgst)
+		return nil, false
+	}
+	return entry.manifest, true
+}
+
+func (cms *cachedManifestService) miss() {
+	if cms.tracker != nil {
+		cms.tracker.Miss()
+	}
+}
@@ -0,0 +1,331 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+type testManifest struct {
+	content string
+}
+
+func (m *testManifest) References() []distribution.Descriptor {
+	return nil
+}
+
+func (m *testManifest) Payload() (string, []byte, error) {
+	return "application/vnd.test.manifest", []byte(m.content), nil
+}
+
+type testManifestService struct {
+	manifests map[digest.Digest]distribution.Manifest
+	gets      int
+}
+
+func (ms *testManifestService) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	_, ok := ms.manifests[dgst]
+	return ok, nil
+}
+
+func (ms *testManifestService) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	ms.gets++
+	m, ok := ms.manifests[dgst]
+	if !ok {
+		return nil, distribution.ErrManifestUnknownRevision{Revision: dgst}
+	}
+	return m, nil
+}
+
+func (ms *testManifestService) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	_, p, err := manifest.Payload()
+	if err != nil {
+		return "", err
+	}
+	dgst := digest.FromBytes(p)
+	ms.manifests[dgst] = manifest
+	return dgst, nil
+}
+
+func (ms *testManifestService) Delete(ctx context.Context, dgst digest.Digest) error {
+	delete(ms.manifests, dgst)
+	return nil
+}
+
+type testTagService struct {
+	tags map[string]digest.Digest
+	gets int
+}
+
+func (ts *testTagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	ts.gets++
+	dgst, ok := ts.tags[tag]
+	if !ok {
+		return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+	return distribution.Descriptor{Digest: dgst}, nil
+}
+
+func (ts *testTagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	ts.tags[tag] = desc.Digest
+	return nil
+}
+
+func (ts *testTagService) Untag(ctx context.Context, tag string) error {
+	delete(ts.tags, tag)
+	return nil
+}
+
+func (ts *testTagService) All(ctx context.Context) ([]string, error) {
+	var tags []string
+	for tag := range ts.tags {
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+func (ts *testTagService) Lookup(ctx context.Context, desc distribution.Descriptor) ([]string, error) {
+	var tags []string
+	for tag, dgst := range ts.tags {
+		if dgst == desc.Digest {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func TestCachedManifestService(t *testing.T) {
+	ctx := context.Background()
+	backend := &testManifestService{manifests: make(map[digest.Digest]distribution.Manifest)}
+	tags := &testTagService{tags: make(map[string]digest.Digest)}
+
+	m1 := &testManifest{content: "first"}
+	d1, err := backend.Put(ctx, m1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags.tags["latest"] = d1
+
+	tracker := &testMetricsTracker{}
+	ttl := 50 * time.Millisecond
+	ms := NewCachedManifestService(backend, tags, ttl, tracker)
+
+	for i := 0; i < 3; i++ {
+		m, err := ms.Get(ctx, "", distribution.WithTag("latest"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m != m1 {
+			t.Fatalf("unexpected manifest: %v", m)
+		}
+	}
+	if backend.gets != 1 || tags.gets != 1 {
+		t.Fatalf("expected a single backend and tag fetch, got %d and %d", backend.gets, tags.gets)
+	}
+	if metrics := tracker.Metrics(); metrics.Hits != 2 || metrics.Misses != 1 {
+		t.Fatalf("unexpected metrics: %#v", metrics)
+	}
+
+	// Fetching by digest shares the cached manifest.
+	if m, err := ms.Get(ctx, d1); err != nil || m != m1 {
+		t.Fatalf("unexpected manifest %v for digest: %v", m, err)
+	}
+	if backend.gets != 1 {
+		t.Fatalf("expected digest fetch to be served from cache, got %d backend fetches", backend.gets)
+	}
+
+	// Once the tag expires, an unchanged digest revalidates the cached
+	// manifest without fetching it again.
+	time.Sleep(2 * ttl)
+	if m, err := ms.Get(ctx, "", distribution.WithTag("latest")); err != nil || m != m1 {
+		t.Fatalf("unexpected manifest %v after revalidation: %v", m, err)
+	}
+	if backend.gets != 1 || tags.gets != 2 {
+		t.Fatalf("expected tag revalidation without backend fetch, got %d and %d", backend.gets, tags.gets)
+	}
+
+	// A changed digest invalidates the cached manifest for the tag.
+	m2 := &testManifest{content: "second"}
+	d2, err := backend.Put(ctx, m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags.tags["latest"] = d2
+
+	time.Sleep(2 * ttl)
+	if m, err := ms.Get(ctx, "", distribution.WithTag("latest")); err != nil || m != m2 {
+		t.Fatalf("unexpected manifest %v after tag change: %v", m, err)
+	}
+	if backend.gets != 2 || tags.gets != 3 {
+		t.Fatalf("expected new manifest to be fetched, got %d backend and %d tag fetches", backend.gets, tags.gets)
+	}
+
+	// Unknown tags are reported by the backend.
+	if _, err := ms.Get(ctx, "", distribution.WithTag("missing")); err == nil {
+		t.Fatal("expected error fetching unknown tag")
+	}
+
+	if err := ms.Delete(ctx, d2); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := ms.Exists(ctx, d2); err != nil || exists {
+		t.Fatalf("expected deleted manifest to be gone: %v, %v", exists, err)
+	}
+}
+
+func TestCachedManifestServiceBounded(t *testing.T) {
+	ctx := context.Background()
+	backend := &testManifestService{manifests: make(map[digest.Digest]distribution.Manifest)}
+	tags := &testTagService{tags: make(map[string]digest.Digest)}
+
+	ms := NewCachedManifestService(backend, tags, time.Hour, nil).(*cachedManifestService)
+	ms.maxEntries = 4
+
+	var first digest.Digest
+	for i := 0; i < 10; i++ {
+		dgst, err := backend.Put(ctx, &testManifest{content: fmt.Sprint(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = dgst
+		}
+		tag := fmt.Sprintf("tag%d", i)
+		tags.tags[tag] = dgst
+
+		if _, err := ms.Get(ctx, "", distribution.WithTag(tag)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(ms.manifests) != 4 || len(ms.tagged) != 4 {
+		t.Fatalf("expected cache to be bounded to 4 entries, got %d manifests and %d tags", len(ms.manifests), len(ms.tagged))
+	}
+	if _, ok := ms.manifests[first]; ok {
+		t.Fatal("expected the oldest manifest to be evicted")
+	}
+}
+
+// blockingManifestService holds Get requests until release is closed.
+type blockingManifestService struct {
+	testManifestService
+	release chan struct{}
+
+	mu   sync.Mutex
+	gets int
+}
+
+func (ms *blockingManifestService) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	ms.mu.Lock()
+	ms.gets++
+	ms.mu.Unlock()
+
+	select {
+	case <-ms.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	m, ok := ms.manifests[dgst]
+	if !ok {
+		return nil, distribution.ErrManifestUnknownRevision{Revision: dgst}
+	}
+	return m, nil
+}
+
+func TestCachedManifestServiceConcurrentGet(t *testing.T) {
+	ctx := context.Background()
+	backend := &blockingManifestService{
+		testManifestService: testManifestService{manifests: make(map[digest.Digest]distribution.Manifest)},
+		release:             make(chan struct{}),
+	}
+	m1 := &testManifest{content: "concurrent"}
+	d1, err := backend.Put(ctx, m1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms := NewCachedManifestService(backend, &testTagService{}, time.Hour, nil)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m, err := ms.Get(ctx, d1)
+			if err == nil && m != m1 {
+				err = fmt.Errorf("unexpected manifest: %v", m)
+			}
+			errs <- err
+		}()
+	}
+
+	// Give the requests time to queue behind the first backend fetch.
+	time.Sleep(50 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backend.gets != 1 {
+		t.Fatalf("expected concurrent misses to share a backend fetch, got %d fetches", backend.gets)
+	}
+}
+
+func TestCachedManifestServiceCancelledFetch(t *testing.T) {
+	backend := &blockingManifestService{
+		testManifestService: testManifestService{manifests: make(map[digest.Digest]distribution.Manifest)},
+		release:             make(chan struct{}),
+	}
+	m1 := &testManifest{content: "cancelled"}
+	d1, err := backend.Put(context.Background(), m1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ms := NewCachedManifestService(backend, &testTagService{}, time.Hour, nil)
+
+	// The first caller starts the shared fetch and then goes away.
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := ms.Get(ctx, d1)
+		first <- err
+	}()
+
+	second := make(chan error, 1)
+	go func() {
+		m, err := ms.Get(context.Background(), d1)
+		if err == nil && m != m1 {
+			err = fmt.Errorf("unexpected manifest: %v", m)
+		}
+		second <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("expected cancelled caller to return context.Canceled, got %v", err)
+	}
+
+	close(backend.release)
+	if err := <-second; err != nil {
+		t.Fatalf("expected remaining caller to succeed, got %v", err)
+	}
+
+	backend.mu.Lock()
+	gets := backend.gets
+	backend.mu.Unlock()
+	if gets != 1 {
+		t.Fatalf("expected a single backend fetch, got %d", gets)
+	}
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+
+	prometheus "github.com/docker/distribution/metrics"
+	promclient "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// trackers holds the metrics trackers registered by name.
+	trackers   = make(map[string]MetricsTracker)
+	trackersMu sync.Mutex
+)
+
+// RegisterMetricsTracker exposes the counters of the tracker in the storage
+// prometheus namespace as registry_storage_cache_tracker_total, labeled with
+// the given name and the same types as registry_storage_cache_total.
+// Registering a name again replaces its tracker.
+func RegisterMetricsTracker(name string, tracker MetricsTracker) {
+	trackersMu.Lock()
+	_, registered := trackers[name]
+	trackers[name] = tracker
+	trackersMu.Unlock()
+	if registered {
+		return
+	}
+
+	for _, counter := range trackerCounters(promclient.Labels{"tracker": name}, func() MetricsTracker {
+		trackersMu.Lock()
+		defer trackersMu.Unlock()
+		return trackers[name]
+	}) {
+		prometheus.StorageNamespace.Add(counter)
+	}
+}
+
+// trackerCounters returns counter funcs for the requests, hits and misses
+// of the tracker returned by current, named registry_storage_cache_tracker_total
+// and labeled by type in addition to the given labels.
+func trackerCounters(labels promclient.Labels, current func() MetricsTracker) []promclient.Collector {
+	counters := map[string]func(Metrics) uint64{
+		"Request": func(m Metrics) uint64 { return m.Requests },
+		"Hit":     func(m Metrics) uint64 { return m.Hits },
+		"Miss":    func(m Metrics) uint64 { return m.Misses },
+	}
+
+	var collectors []promclient.Collector
+	for typ, value := range counters {
+		value := value
+		constLabels := promclient.Labels{"type": typ}
+		for k, v := range labels {
+			constLabels[k] = v
+		}
+		collectors = append(collectors, promclient.NewCounterFunc(promclient.CounterOpts{
+			Namespace:   prometheus.NamespacePrefix,
+			Subsystem:   "storage",
+			Name:        "cache_tracker_total",
+			Help:        "The number of cache requests counted by a metrics tracker",
+			ConstLabels: constLabels,
+		}, func() float64 {
+			return float64(value(current().Metrics()))
+		}))
+	}
+	return collectors
+}
+
+// NewMetricsHandler returns an http.Handler which renders the metrics
+// collected by the tracker as JSON. Requests accepting "text/plain" receive
+// the tracker's counters in the Prometheus text format, independently of
+// RegisterMetricsTracker.
+func NewMetricsHandler(tracker MetricsTracker) http.Handler {
+	registry := promclient.NewRegistry()
+	for _, counter := range trackerCounters(nil, func() MetricsTracker { return tracker }) {
+		registry.MustRegister(counter)
+	}
+
+	return &metricsHandler{
+		tracker:    tracker,
+		prometheus: promhttp.HandlerFor(registry, promhttp.HandlerOpts{}),
+	}
+}
+
+type metricsHandler struct {
+	tracker    MetricsTracker
+	prometheus http.Handler
+}
+
+func (mh *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+		mh.prometheus.ServeHTTP(w, r)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(mh.tracker.Metrics()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
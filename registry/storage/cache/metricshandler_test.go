@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	prometheus "github.com/docker/distribution/metrics"
+	"github.com/docker/go-metrics"
+)
+
+type testMetricsTracker struct {
+	metrics Metrics
+}
+
+func (t *testMetricsTracker) Hit() {
+	t.metrics.Requests++
+	t.metrics.Hits++
+}
+
+func (t *testMetricsTracker) Miss() {
+	t.metrics.Requests++
+	t.metrics.Misses++
+}
+
+func (t *testMetricsTracker) Metrics() Metrics {
+	return t.metrics
+}
+
+func (t *testMetricsTracker) Logger(context.Context) Logger {
+	return nil
+}
+
+func TestMetricsHandler(t *testing.T) {
+	tracker := &testMetricsTracker{}
+	tracker.Hit()
+	tracker.Hit()
+	tracker.Miss()
+
+	handler := NewMetricsHandler(tracker)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	}
+
+	var m Metrics
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("error decoding metrics: %v", err)
+	}
+	if m != tracker.Metrics() {
+		t.Fatalf("unexpected metrics: %#v != %#v", m, tracker.Metrics())
+	}
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "text/plain")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected content type: %q", w.Header().Get("Content-Type"))
+	}
+	for _, expected := range []string{
+		`registry_storage_cache_tracker_total{type="Request"} 3`,
+		`registry_storage_cache_tracker_total{type="Hit"} 2`,
+		`registry_storage_cache_tracker_total{type="Miss"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Fatalf("expected %q in output:\n%s", expected, w.Body.String())
+		}
+	}
+	// Only the counters of the supplied tracker are rendered.
+	if strings.Contains(w.Body.String(), "go_goroutines") {
+		t.Fatalf("unexpected process metrics in output:\n%s", w.Body.String())
+	}
+
+	req = httptest.NewRequest("POST", "/metrics", nil)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status code for POST: %d", w.Code)
+	}
+}
+
+func TestRegisterMetricsTracker(t *testing.T) {
+	tracker := &testMetricsTracker{}
+	tracker.Hit()
+	tracker.Miss()
+
+	RegisterMetricsTracker("test", tracker)
+	metrics.Register(prometheus.StorageNamespace)
+	defer metrics.Deregister(prometheus.StorageNamespace)
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, req)
+
+	for _, expected := range []string{
+		`registry_storage_cache_tracker_total{tracker="test",type="Request"} 2`,
+		`registry_storage_cache_tracker_total{tracker="test",type="Hit"} 1`,
+		`registry_storage_cache_tracker_total{tracker="test",type="Miss"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Fatalf("expected %q in output:\n%s", expected, w.Body.String())
+		}
+	}
+}
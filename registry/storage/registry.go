@@ -18,6 +18,7 @@ type registry struct {
 	blobServer                   *blobServer
 	statter                      *blobStatter // global statter service.
 	blobDescriptorCacheProvider  cache.BlobDescriptorCacheProvider
+	blobDescriptorCacheGuard     *cache.WriteGuard
 	deleteEnabled                bool
 	schema1Enabled               bool
 	resumableDigestEnabled       bool
@@ -102,22 +103,24 @@ func BlobDescriptorServiceFactory(factory distribution.BlobDescriptorServiceFact
 // NewRegistry. It creates a cached blob statter for use by the
 // registry.
 func BlobDescriptorCacheProvider(blobDescriptorCacheProvider cache.BlobDescriptorCacheProvider) RegistryOption {
-	// TODO(aaronl): The duplication of statter across several objects is
-	// ugly, and prevents us from using interface types in the registry
-	// struct. Ideally, blobStore and blobServer should be lazily
-	// initialized, and use the current value of
-	// blobDescriptorCacheProvider.
 	return func(registry *registry) error {
 		if blobDescriptorCacheProvider != nil {
-			statter := cache.NewCachedBlobStatter(blobDescriptorCacheProvider, registry.statter)
-			registry.blobStore.statter = statter
-			registry.blobServer.statter = statter
 			registry.blobDescriptorCacheProvider = blobDescriptorCacheProvider
 		}
 		return nil
 	}
 }
 
+// BlobDescriptorCacheBreaker returns a functional option for NewRegistry. It
+// suspends writes to the blob descriptor cache after repeated failures, as
+// described by the config. The failures are counted across all repositories.
+func BlobDescriptorCacheBreaker(config cache.BreakerConfig) RegistryOption {
+	return func(registry *registry) error {
+		registry.blobDescriptorCacheGuard = cache.NewWriteGuard(config)
+		return nil
+	}
+}
+
 // NewRegistry creates a new registry instance from the provided driver. The
 // resulting registry may be shared by multiple goroutines but is cheap to
 // allocate. If the Redirect option is specified, the backend blob server will
@@ -140,9 +143,10 @@ func NewRegistry(ctx context.Context, driver storagedriver.StorageDriver, option
 			statter: statter,
 			pathFn:  bs.path,
 		},
-		statter:                statter,
-		resumableDigestEnabled: true,
-		driver:                 driver,
+		statter:                  statter,
+		resumableDigestEnabled:   true,
+		driver:                   driver,
+		blobDescriptorCacheGuard: cache.NewWriteGuard(cache.BreakerConfig{}),
 	}
 
 	for _, option := range options {
@@ -151,6 +155,17 @@ func NewRegistry(ctx context.Context, driver storagedriver.StorageDriver, option
 		}
 	}
 
+	if registry.blobDescriptorCacheProvider != nil {
+		// TODO(aaronl): The duplication of statter across several objects is
+		// ugly, and prevents us from using interface types in the registry
+		// struct. Ideally, blobStore and blobServer should be lazily
+		// initialized, and use the current value of
+		// blobDescriptorCacheProvider.
+		cachedStatter := cache.NewCachedBlobStatterWithWriteGuard(registry.blobDescriptorCacheProvider, statter, blobStatterCacheMetrics, registry.blobDescriptorCacheGuard)
+		registry.blobStore.statter = cachedStatter
+		registry.blobServer.statter = cachedStatter
+	}
+
 	return registry, nil
 }
 
@@ -312,7 +327,9 @@ func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
 	}
 
 	if repo.descriptorCache != nil {
-		statter = cache.NewCachedBlobStatter(repo.descriptorCache, statter)
+		// The write guard is shared so that cache failures are rate limited
+		// across requests rather than per blob store instance.
+		statter = cache.NewCachedBlobStatterWithWriteGuard(repo.descriptorCache, statter, blobStatterCacheMetrics, repo.registry.blobDescriptorCacheGuard)
 	}
 
 	if repo.registry.blobDescriptorServiceFactory != nil {